@@ -0,0 +1,100 @@
+package mls
+
+import (
+	"testing"
+	"time"
+)
+
+const auditTestSuite = P256_SHA256_AES128GCM
+
+// leafData returns the raw (pre-hash) content of the i'th leaf in an
+// audit tree of the given size; leafHashes hashes each one the way Add
+// expects its leaf nodes to already be hashed.
+func leafData(n uint) [][]byte {
+	data := make([][]byte, n)
+	for i := range data {
+		data[i] = []byte{byte(i)}
+	}
+	return data
+}
+
+func leafHashes(suite CipherSuite, data [][]byte) []Node {
+	leaves := make([]Node, len(data))
+	for i, d := range data {
+		leaves[i] = leafHash(suite, d)
+	}
+	return leaves
+}
+
+func TestInclusionProofRoundTrip(t *testing.T) {
+	for size := uint(1); size <= 17; size++ {
+		data := leafData(size)
+		tree, err := newTreeFromLeaves(hashDefinition(auditTestSuite), leafHashes(auditTestSuite, data))
+		if err != nil {
+			t.Fatalf("size %d: error constructing tree: %v", size, err)
+		}
+
+		head, err := tree.Head(auditTestSuite, time.Unix(0, 0))
+		if err != nil {
+			t.Fatalf("size %d: error computing head: %v", size, err)
+		}
+
+		for i := uint(0); i < size; i++ {
+			proof, err := tree.InclusionProof(i)
+			if err != nil {
+				t.Fatalf("size %d, leaf %d: error building inclusion proof: %v", size, i, err)
+			}
+
+			if !VerifyInclusion(auditTestSuite, head, leafHash(auditTestSuite, data[i]), uint64(i), proof) {
+				t.Fatalf("size %d, leaf %d: inclusion proof did not verify", size, i)
+			}
+
+			wrongLeaf := append(append([]byte{}, data[i]...), 0xFF)
+			if VerifyInclusion(auditTestSuite, head, leafHash(auditTestSuite, wrongLeaf), uint64(i), proof) {
+				t.Fatalf("size %d, leaf %d: inclusion proof verified against the wrong leaf", size, i)
+			}
+		}
+	}
+}
+
+func TestConsistencyProofRoundTrip(t *testing.T) {
+	for newSize := uint(1); newSize <= 17; newSize++ {
+		data := leafData(newSize)
+		tree, err := newTreeFromLeaves(hashDefinition(auditTestSuite), leafHashes(auditTestSuite, data))
+		if err != nil {
+			t.Fatalf("newSize %d: error constructing tree: %v", newSize, err)
+		}
+
+		newHead, err := tree.Head(auditTestSuite, time.Unix(0, 0))
+		if err != nil {
+			t.Fatalf("newSize %d: error computing new head: %v", newSize, err)
+		}
+
+		for oldSize := uint(1); oldSize <= newSize; oldSize++ {
+			oldTree, err := newTreeFromLeaves(hashDefinition(auditTestSuite), leafHashes(auditTestSuite, data[:oldSize]))
+			if err != nil {
+				t.Fatalf("oldSize %d: error constructing old tree: %v", oldSize, err)
+			}
+
+			oldHead, err := oldTree.Head(auditTestSuite, time.Unix(0, 0))
+			if err != nil {
+				t.Fatalf("oldSize %d: error computing old head: %v", oldSize, err)
+			}
+
+			proof, err := tree.ConsistencyProof(oldSize, newSize)
+			if err != nil {
+				t.Fatalf("oldSize %d, newSize %d: error building consistency proof: %v", oldSize, newSize, err)
+			}
+
+			if !VerifyConsistency(auditTestSuite, oldHead, newHead, proof) {
+				t.Fatalf("oldSize %d, newSize %d: consistency proof did not verify", oldSize, newSize)
+			}
+
+			tamperedHead := oldHead
+			tamperedHead.RootHash = append(append([]byte{}, oldHead.RootHash...), 0xFF)
+			if VerifyConsistency(auditTestSuite, tamperedHead, newHead, proof) {
+				t.Fatalf("oldSize %d, newSize %d: consistency proof verified against a tampered old head", oldSize, newSize)
+			}
+		}
+	}
+}
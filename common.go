@@ -0,0 +1,13 @@
+package mls
+
+import "errors"
+
+// Node is the value stored at a position in a hash tree. Trees are generic
+// over the concrete node type, which is supplied by a nodeDefinition that
+// knows how to create, combine, and validate nodes for a particular use of
+// the tree (e.g. ratchet tree public keys, Merkle leaves).
+type Node interface{}
+
+// InvalidNodeError is returned by a nodeDefinition callback when a Node
+// passed to it is not of the type that definition expects.
+var InvalidNodeError = errors.New("mls: invalid node")
@@ -0,0 +1,51 @@
+package mls
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func unhex(s string) []byte {
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func assertNotError(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %v", msg, err)
+	}
+}
+
+func assertTrue(t *testing.T, ok bool, msg string) {
+	t.Helper()
+	if !ok {
+		t.Fatalf("%s", msg)
+	}
+}
+
+func assertEquals(t *testing.T, actual, expected interface{}) {
+	t.Helper()
+	if actual != expected {
+		t.Fatalf("Unexpected value: %v != %v", actual, expected)
+	}
+}
+
+func assertByteEquals(t *testing.T, actual, expected []byte) {
+	t.Helper()
+	if !bytes.Equal(actual, expected) {
+		t.Fatalf("Byte strings not equal: %x != %x", actual, expected)
+	}
+}
+
+func assertDeepEquals(t *testing.T, actual, expected interface{}) {
+	t.Helper()
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Values not equal: %v != %v", actual, expected)
+	}
+}
@@ -0,0 +1,545 @@
+package mls
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+
+	"github.com/bifurcation/mint/syntax"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// CipherSuite identifies the tuple of algorithms (KEM/KDF curve, hash, and
+// AEAD) used to protect a group's key schedule and application messages.
+type CipherSuite uint16
+
+const (
+	P256_SHA256_AES128GCM          CipherSuite = 0x0000
+	P521_SHA512_AES256GCM          CipherSuite = 0x0001
+	X25519_SHA256_AES128GCM        CipherSuite = 0x0002
+	X448_SHA512_AES256GCM          CipherSuite = 0x0003
+	X25519_SHA256_CHACHA20POLY1305 CipherSuite = 0x0004
+	X448_SHA512_CHACHA20POLY1305   CipherSuite = 0x0005
+)
+
+func (suite CipherSuite) String() string {
+	switch suite {
+	case P256_SHA256_AES128GCM:
+		return "P256_SHA256_AES128GCM"
+	case P521_SHA512_AES256GCM:
+		return "P521_SHA512_AES256GCM"
+	case X25519_SHA256_AES128GCM:
+		return "X25519_SHA256_AES128GCM"
+	case X448_SHA512_AES256GCM:
+		return "X448_SHA512_AES256GCM"
+	case X25519_SHA256_CHACHA20POLY1305:
+		return "X25519_SHA256_CHACHA20POLY1305"
+	case X448_SHA512_CHACHA20POLY1305:
+		return "X448_SHA512_CHACHA20POLY1305"
+	default:
+		return fmt.Sprintf("UNKNOWN_SUITE(%04x)", uint16(suite))
+	}
+}
+
+// suiteParams captures the algorithm sizes and constructors for a
+// CipherSuite so that the rest of the package can stay table-driven instead
+// of re-switching on the suite in every function that needs a key or nonce
+// size.
+type suiteParams struct {
+	newHash       func() hash.Hash
+	hashSize      int
+	aeadKeySize   int
+	aeadNonceSize int
+	newAEAD       func(key []byte) (cipher.AEAD, error)
+	curve         curveParams
+}
+
+var suiteParamsTable = map[CipherSuite]suiteParams{
+	P256_SHA256_AES128GCM: {
+		newHash: sha256.New, hashSize: 32,
+		aeadKeySize: 16, aeadNonceSize: 12, newAEAD: newAESGCM,
+		curve: curveP256,
+	},
+	P521_SHA512_AES256GCM: {
+		newHash: sha512.New, hashSize: 64,
+		aeadKeySize: 32, aeadNonceSize: 12, newAEAD: newAESGCM,
+		curve: curveP521,
+	},
+	X25519_SHA256_AES128GCM: {
+		newHash: sha256.New, hashSize: 32,
+		aeadKeySize: 16, aeadNonceSize: 12, newAEAD: newAESGCM,
+		curve: curveX25519,
+	},
+	X448_SHA512_AES256GCM: {
+		newHash: sha512.New, hashSize: 64,
+		aeadKeySize: 32, aeadNonceSize: 12, newAEAD: newAESGCM,
+		curve: curveX448,
+	},
+	X25519_SHA256_CHACHA20POLY1305: {
+		newHash: sha256.New, hashSize: 32,
+		aeadKeySize: chacha20poly1305.KeySize, aeadNonceSize: chacha20poly1305.NonceSizeX, newAEAD: newXChaCha20Poly1305,
+		curve: curveX25519,
+	},
+	X448_SHA512_CHACHA20POLY1305: {
+		newHash: sha512.New, hashSize: 64,
+		aeadKeySize: chacha20poly1305.KeySize, aeadNonceSize: chacha20poly1305.NonceSizeX, newAEAD: newXChaCha20Poly1305,
+		curve: curveX448,
+	},
+}
+
+func (suite CipherSuite) params() suiteParams {
+	params, ok := suiteParamsTable[suite]
+	if !ok {
+		panic(fmt.Sprintf("mls: unsupported ciphersuite %v", suite))
+	}
+	return params
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// newXChaCha20Poly1305 uses the extended, 24-byte-nonce variant of
+// ChaCha20-Poly1305 so that the full MLS handshake sequence number can be
+// used as a nonce without a separate counter/salt construction.
+func newXChaCha20Poly1305(key []byte) (cipher.AEAD, error) {
+	return chacha20poly1305.NewX(key)
+}
+
+// digest hashes in with the suite's hash algorithm.
+func (suite CipherSuite) digest(in []byte) []byte {
+	h := suite.params().newHash()
+	h.Write(in)
+	return h.Sum(nil)
+}
+
+// newAEAD constructs an AEAD instance for the suite, keyed with key. The
+// caller is responsible for supplying a key of suite.params().aeadKeySize
+// bytes.
+func (suite CipherSuite) newAEAD(key []byte) (cipher.AEAD, error) {
+	return suite.params().newAEAD(key)
+}
+
+func (suite CipherSuite) hkdfExtract(salt, ikm []byte) []byte {
+	params := suite.params()
+	mac := hmac.New(params.newHash, salt)
+	mac.Write(ikm)
+	return mac.Sum(nil)
+}
+
+func (suite CipherSuite) hkdfExpand(prk, info []byte, size int) []byte {
+	params := suite.params()
+	out := make([]byte, size)
+	reader := hkdf.Expand(params.newHash, prk, info)
+	if _, err := io.ReadFull(reader, out); err != nil {
+		panic(err)
+	}
+	return out
+}
+
+func (suite CipherSuite) hpke() hpkeScheme {
+	return hpkeScheme{suite: suite}
+}
+
+// hkdfLabel is the MLS "ExpandWithLabel" wrapper: it binds an HKDF-Expand
+// call to a human-readable label and a context, so that secrets derived for
+// different purposes from the same input secret can never collide.
+type hkdfLabel struct {
+	Length  uint16
+	Label   []byte `tls:"head=1"`
+	Context []byte `tls:"head=4"`
+}
+
+func (suite CipherSuite) expandWithLabel(secret []byte, label string, context []byte, length int) []byte {
+	lbl := hkdfLabel{
+		Length:  uint16(length),
+		Label:   []byte("mls10 " + label),
+		Context: context,
+	}
+
+	enc, err := syntax.Marshal(lbl)
+	if err != nil {
+		panic(err)
+	}
+
+	return suite.hkdfExpand(secret, enc, length)
+}
+
+// DeriveSecret derives a new secret of the suite's hash size from secret,
+// bound to label and context via expandWithLabel.
+func (suite CipherSuite) DeriveSecret(secret []byte, label string, context []byte) []byte {
+	return suite.expandWithLabel(secret, label, context, suite.params().hashSize)
+}
+
+// DeriveAppKeys derives the (key, nonce) pair used to protect application
+// messages at a given generation of the label's ratchet, sized to match the
+// suite's AEAD.
+func (suite CipherSuite) DeriveAppKeys(secret []byte, label string, generation uint32) (key, nonce []byte) {
+	params := suite.params()
+
+	genBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(genBytes, generation)
+
+	key = suite.expandWithLabel(secret, label+" key", genBytes, params.aeadKeySize)
+	nonce = suite.expandWithLabel(secret, label+" nonce", genBytes, params.aeadNonceSize)
+	return key, nonce
+}
+
+///
+/// HPKE
+///
+
+// HPKEPublicKey is the wire representation of an HPKE (KEM) public key.
+type HPKEPublicKey struct {
+	Data []byte `tls:"head=2"`
+}
+
+// HPKEPrivateKey is an HPKE private key together with its public half.
+type HPKEPrivateKey struct {
+	PublicKey HPKEPublicKey
+	Data      []byte `tls:"head=2"`
+}
+
+// HPKECiphertext is the output of an HPKE single-shot encryption: the
+// encapsulated KEM output plus the AEAD-sealed payload.
+type HPKECiphertext struct {
+	KEMOutput  []byte `tls:"head=2"`
+	Ciphertext []byte `tls:"head=2"`
+}
+
+// hpkeScheme implements a minimal single-shot HPKE (RFC 9180 "base mode"
+// shape): ECDH with an ephemeral key produces a shared secret, which is fed
+// through the suite's KDF to derive an AEAD key and nonce.
+type hpkeScheme struct {
+	suite CipherSuite
+}
+
+func (h hpkeScheme) Generate() (HPKEPrivateKey, error) {
+	return h.suite.params().curve.generate()
+}
+
+func (h hpkeScheme) Derive(seed []byte) (HPKEPrivateKey, error) {
+	return h.suite.params().curve.derive(seed)
+}
+
+func (h hpkeScheme) Encrypt(pub HPKEPublicKey, aad, pt []byte) (HPKECiphertext, error) {
+	curve := h.suite.params().curve
+	ephemeral, err := curve.generate()
+	if err != nil {
+		return HPKECiphertext{}, err
+	}
+
+	secret, err := curve.exchange(ephemeral, pub)
+	if err != nil {
+		return HPKECiphertext{}, err
+	}
+
+	aead, nonce, err := h.keyNonce(secret, ephemeral.PublicKey.Data, pub.Data)
+	if err != nil {
+		return HPKECiphertext{}, err
+	}
+
+	ct := aead.Seal(nil, nonce, pt, aad)
+	return HPKECiphertext{
+		KEMOutput:  ephemeral.PublicKey.Data,
+		Ciphertext: ct,
+	}, nil
+}
+
+func (h hpkeScheme) Decrypt(priv HPKEPrivateKey, aad []byte, ct HPKECiphertext) ([]byte, error) {
+	curve := h.suite.params().curve
+	ephemeralPub := HPKEPublicKey{Data: ct.KEMOutput}
+
+	secret, err := curve.exchange(priv, ephemeralPub)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, nonce, err := h.keyNonce(secret, ct.KEMOutput, priv.PublicKey.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Open(nil, nonce, ct.Ciphertext, aad)
+}
+
+// keyNonce derives an AEAD key and nonce from an ECDH shared secret, bound
+// to the ephemeral and recipient public keys so that a given secret cannot
+// be replayed against a different key pair.
+func (h hpkeScheme) keyNonce(secret, enc, pub []byte) (cipher.AEAD, []byte, error) {
+	params := h.suite.params()
+	context := append(append([]byte{}, enc...), pub...)
+	prk := h.suite.hkdfExtract(context, secret)
+
+	key := h.suite.hkdfExpand(prk, []byte("mls hpke key"), params.aeadKeySize)
+	nonce := h.suite.hkdfExpand(prk, []byte("mls hpke nonce"), params.aeadNonceSize)
+
+	aead, err := params.newAEAD(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return aead, nonce, nil
+}
+
+///
+/// ECDH curves
+///
+
+type curveParams struct {
+	generate func() (HPKEPrivateKey, error)
+	derive   func(seed []byte) (HPKEPrivateKey, error)
+	exchange func(priv HPKEPrivateKey, pub HPKEPublicKey) ([]byte, error)
+}
+
+func ecdhCurveParams(curve ecdh.Curve) curveParams {
+	keyFromBytes := func(data []byte) (*ecdh.PrivateKey, error) {
+		return curve.NewPrivateKey(data)
+	}
+
+	return curveParams{
+		generate: func() (HPKEPrivateKey, error) {
+			key, err := curve.GenerateKey(rand.Reader)
+			if err != nil {
+				return HPKEPrivateKey{}, err
+			}
+			return HPKEPrivateKey{
+				Data:      key.Bytes(),
+				PublicKey: HPKEPublicKey{Data: key.PublicKey().Bytes()},
+			}, nil
+		},
+		derive: func(seed []byte) (HPKEPrivateKey, error) {
+			// Raw HKDF-expanded bytes are only a valid scalar for a Weierstrass
+			// curve (P-256, P-521) with overwhelming-but-not-certain probability,
+			// so follow RFC 9180's DeriveKeyPair rejection-sampling pattern: on
+			// rejection, re-expand with a counter folded into the label and try
+			// again, up to a generous bound.
+			size := curveKeySize(curve)
+			for counter := 0; counter < 256; counter++ {
+				label := []byte("mls key derivation")
+				if counter > 0 {
+					label = append(append([]byte{}, label...), byte(counter))
+				}
+				raw := expandSeedWithLabel(seed, size, label)
+				key, err := curve.NewPrivateKey(raw)
+				if err != nil {
+					continue
+				}
+				return HPKEPrivateKey{
+					Data:      key.Bytes(),
+					PublicKey: HPKEPublicKey{Data: key.PublicKey().Bytes()},
+				}, nil
+			}
+			return HPKEPrivateKey{}, fmt.Errorf("mls: could not derive a valid private key from seed")
+		},
+		exchange: func(priv HPKEPrivateKey, pub HPKEPublicKey) ([]byte, error) {
+			privKey, err := keyFromBytes(priv.Data)
+			if err != nil {
+				return nil, err
+			}
+			pubKey, err := curve.NewPublicKey(pub.Data)
+			if err != nil {
+				return nil, err
+			}
+			return privKey.ECDH(pubKey)
+		},
+	}
+}
+
+func curveKeySize(curve ecdh.Curve) int {
+	switch curve {
+	case ecdh.X25519():
+		return 32
+	case ecdh.P256():
+		return 32
+	case ecdh.P521():
+		return 66
+	default:
+		return 32
+	}
+}
+
+// expandSeed stretches an arbitrary-length seed into exactly size bytes of
+// key material using HKDF-Expand with a fixed label, so that Derive is
+// deterministic in the seed without leaking the seed's raw bytes directly
+// into curve-specific encodings.
+func expandSeed(seed []byte, size int) []byte {
+	return expandSeedWithLabel(seed, size, []byte("mls key derivation"))
+}
+
+func expandSeedWithLabel(seed []byte, size int, label []byte) []byte {
+	out := make([]byte, size)
+	reader := hkdf.Expand(sha256.New, seed, label)
+	if _, err := io.ReadFull(reader, out); err != nil {
+		panic(err)
+	}
+	return out
+}
+
+var (
+	curveP256   = ecdhCurveParams(ecdh.P256())
+	curveP521   = ecdhCurveParams(ecdh.P521())
+	curveX25519 = ecdhCurveParams(ecdh.X25519())
+	// The standard library does not implement Curve448, so the X448 suites
+	// borrow the X25519 field operations rather than faking a non-commutative
+	// "Diffie-Hellman" with a hash. This keeps the shared-secret property
+	// HPKE correctness depends on (exchange(a, B) == exchange(b, A)) intact;
+	// it is not wire-compatible with other Curve448 implementations.
+	curveX448 = ecdhCurveParams(ecdh.X25519())
+)
+
+///
+/// Signatures
+///
+
+// SignatureScheme identifies the signature algorithm used for a
+// participant's identity or credential key.
+type SignatureScheme uint16
+
+const (
+	ECDSA_SECP256R1_SHA256 SignatureScheme = 0x0000
+	Ed25519                SignatureScheme = 0x0001
+)
+
+func (scheme SignatureScheme) String() string {
+	switch scheme {
+	case ECDSA_SECP256R1_SHA256:
+		return "ECDSA_SECP256R1_SHA256"
+	case Ed25519:
+		return "Ed25519"
+	default:
+		return fmt.Sprintf("UNKNOWN_SCHEME(%04x)", uint16(scheme))
+	}
+}
+
+// SignaturePublicKey is the wire representation of a signing public key.
+type SignaturePublicKey struct {
+	Data []byte `tls:"head=2"`
+}
+
+// SignaturePrivateKey is a signing private key together with its public
+// half.
+type SignaturePrivateKey struct {
+	PublicKey SignaturePublicKey
+	Data      []byte `tls:"head=2"`
+}
+
+func (scheme SignatureScheme) Generate() (SignaturePrivateKey, error) {
+	switch scheme {
+	case ECDSA_SECP256R1_SHA256:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return SignaturePrivateKey{}, err
+		}
+		return ecdsaToPrivateKey(key), nil
+
+	case Ed25519:
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return SignaturePrivateKey{}, err
+		}
+		return SignaturePrivateKey{
+			Data:      priv,
+			PublicKey: SignaturePublicKey{Data: pub},
+		}, nil
+	}
+
+	return SignaturePrivateKey{}, fmt.Errorf("mls: unsupported signature scheme %v", scheme)
+}
+
+func (scheme SignatureScheme) Derive(seed []byte) (SignaturePrivateKey, error) {
+	switch scheme {
+	case ECDSA_SECP256R1_SHA256:
+		raw := expandSeed(seed, 32)
+		d := new(big.Int).SetBytes(raw)
+		curve := elliptic.P256()
+		key := new(ecdsa.PrivateKey)
+		key.Curve = curve
+		key.D = d
+		key.PublicKey.X, key.PublicKey.Y = curve.ScalarBaseMult(raw)
+		return ecdsaToPrivateKey(key), nil
+
+	case Ed25519:
+		raw := expandSeed(seed, ed25519.SeedSize)
+		priv := ed25519.NewKeyFromSeed(raw)
+		return SignaturePrivateKey{
+			Data:      priv,
+			PublicKey: SignaturePublicKey{Data: priv.Public().(ed25519.PublicKey)},
+		}, nil
+	}
+
+	return SignaturePrivateKey{}, fmt.Errorf("mls: unsupported signature scheme %v", scheme)
+}
+
+func (scheme SignatureScheme) Sign(priv *SignaturePrivateKey, message []byte) ([]byte, error) {
+	switch scheme {
+	case ECDSA_SECP256R1_SHA256:
+		key, err := privateKeyToECDSA(*priv)
+		if err != nil {
+			return nil, err
+		}
+		digest := sha256.Sum256(message)
+		return ecdsa.SignASN1(rand.Reader, key, digest[:])
+
+	case Ed25519:
+		return ed25519.Sign(ed25519.PrivateKey(priv.Data), message), nil
+	}
+
+	return nil, fmt.Errorf("mls: unsupported signature scheme %v", scheme)
+}
+
+func (scheme SignatureScheme) Verify(pub *SignaturePublicKey, message, signature []byte) bool {
+	switch scheme {
+	case ECDSA_SECP256R1_SHA256:
+		x, y := elliptic.Unmarshal(elliptic.P256(), pub.Data)
+		if x == nil {
+			return false
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		digest := sha256.Sum256(message)
+		return ecdsa.VerifyASN1(key, digest[:], signature)
+
+	case Ed25519:
+		return ed25519.Verify(ed25519.PublicKey(pub.Data), message, signature)
+	}
+
+	return false
+}
+
+func ecdsaToPrivateKey(key *ecdsa.PrivateKey) SignaturePrivateKey {
+	pub := elliptic.Marshal(key.Curve, key.PublicKey.X, key.PublicKey.Y)
+	return SignaturePrivateKey{
+		Data:      key.D.Bytes(),
+		PublicKey: SignaturePublicKey{Data: pub},
+	}
+}
+
+func privateKeyToECDSA(priv SignaturePrivateKey) (*ecdsa.PrivateKey, error) {
+	curve := elliptic.P256()
+	x, y := elliptic.Unmarshal(curve, priv.PublicKey.Data)
+	if x == nil {
+		return nil, fmt.Errorf("mls: invalid ECDSA public key")
+	}
+
+	key := new(ecdsa.PrivateKey)
+	key.Curve = curve
+	key.X, key.Y = x, y
+	key.D = new(big.Int).SetBytes(priv.Data)
+	return key, nil
+}
@@ -12,6 +12,8 @@ var supportedSuites = []CipherSuite{
 	P521_SHA512_AES256GCM,
 	X25519_SHA256_AES128GCM,
 	X448_SHA512_AES256GCM,
+	X25519_SHA256_CHACHA20POLY1305,
+	X448_SHA512_CHACHA20POLY1305,
 }
 
 var supportedSchemes = []SignatureScheme{
@@ -19,6 +21,12 @@ var supportedSchemes = []SignatureScheme{
 	Ed25519,
 }
 
+// priv is scratch storage shared by the crypto test-vector generation
+// helpers below; they assign into it rather than declaring a local so that
+// generateCryptoVectors and verifyCryptoVectors can be compared line for
+// line against each other.
+var priv HPKEPrivateKey
+
 func TestDigest(t *testing.T) {
 	in := unhex("6162636462636465636465666465666765666768666768696768696a68696a6b6" +
 		"96a6b6c6a6b6c6d6b6c6d6e6c6d6e6f6d6e6f706e6f7071")
@@ -29,9 +37,9 @@ func TestDigest(t *testing.T) {
 	for _, suite := range supportedSuites {
 		var out []byte
 		switch suite {
-		case P256_SHA256_AES128GCM, X25519_SHA256_AES128GCM:
+		case P256_SHA256_AES128GCM, X25519_SHA256_AES128GCM, X25519_SHA256_CHACHA20POLY1305:
 			out = out256
-		case P521_SHA512_AES256GCM, X448_SHA512_AES256GCM:
+		case P521_SHA512_AES256GCM, X448_SHA512_AES256GCM, X448_SHA512_CHACHA20POLY1305:
 			out = out512
 		}
 
@@ -66,6 +74,25 @@ func TestEncryptDecrypt(t *testing.T) {
 
 	encryptDecrypt := func(suite CipherSuite) func(t *testing.T) {
 		return func(t *testing.T) {
+			switch suite {
+			case X25519_SHA256_CHACHA20POLY1305, X448_SHA512_CHACHA20POLY1305:
+				// No widely-cited fixed-key XChaCha20-Poly1305 vector is
+				// wired up here yet, so exercise the AEAD as a round trip
+				// instead of against a golden ciphertext.
+				aead, err := suite.newAEAD(bytes.Repeat([]byte{0x01}, 32))
+				assertNotError(t, err, "Error creating AEAD")
+
+				nonce := bytes.Repeat([]byte{0x02}, aead.NonceSize())
+				aad := []byte("doo-bee-doo")
+				pt := []byte("Attack at dawn!")
+
+				encrypted := aead.Seal(nil, nonce, pt, aad)
+				decrypted, err := aead.Open(nil, nonce, encrypted, aad)
+				assertNotError(t, err, "Error in decryption")
+				assertByteEquals(t, decrypted, pt)
+				return
+			}
+
 			var key, nonce, aad, pt, ct []byte
 			switch suite {
 			case P256_SHA256_AES128GCM, X25519_SHA256_AES128GCM:
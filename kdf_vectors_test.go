@@ -0,0 +1,144 @@
+package mls
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bifurcation/mint/syntax"
+)
+
+var updateKDFVectors = flag.Bool("update", false, "regenerate KDF test vector fixtures instead of verifying them")
+
+const kdfVectorsPath = "testdata/kdf_vectors.bin"
+
+// kdfExpandCase pins down HKDF-Expand for one output length L, so that a
+// suite whose derived key/nonce length doesn't match its AEAD's expected
+// sizes - the bug class a newly-added suite is prone to - shows up as a
+// mismatch here instead of only at encryption time.
+type kdfExpandCase struct {
+	Length uint16
+	Out    []byte `tls:"head=2"`
+}
+
+type kdfTestVectorCase struct {
+	CipherSuite     CipherSuite
+	HKDFExtractOut  []byte          `tls:"head=1"`
+	HKDFExpandOut   []kdfExpandCase `tls:"head=2"`
+	DeriveSecretOut []byte          `tls:"head=1"`
+	AppKey          []byte          `tls:"head=1"`
+	AppNonce        []byte          `tls:"head=1"`
+}
+
+// KDFTestVectors mirrors what interop test suites check for the KDF half of
+// a ciphersuite: HKDF-Extract, HKDF-Expand at several output lengths,
+// DeriveSecret (the MLS label-wrapped Expand used throughout the key
+// schedule), and the app key/nonce pair that DeriveAppKeys feeds to the
+// suite's AEAD.
+type KDFTestVectors struct {
+	HKDFExtractSalt     []byte   `tls:"head=1"`
+	HKDFExtractIKM      []byte   `tls:"head=1"`
+	ExpandInfo          []byte   `tls:"head=1"`
+	ExpandLengths       []uint16 `tls:"head=1"`
+	DeriveSecretLabel   []byte   `tls:"head=1"`
+	DeriveSecretContext []byte   `tls:"head=1"`
+	AppKeyLabel         []byte   `tls:"head=1"`
+	AppKeyGeneration    uint32
+	Cases               []kdfTestVectorCase `tls:"head=4"`
+}
+
+func generateKDFVectors(t *testing.T) []byte {
+	tv := KDFTestVectors{
+		HKDFExtractSalt:     []byte{0, 1, 2, 3},
+		HKDFExtractIKM:      []byte{4, 5, 6, 7},
+		ExpandInfo:          []byte("mls kdf vector expand info"),
+		ExpandLengths:       []uint16{16, 32, 64},
+		DeriveSecretLabel:   []byte("test secret"),
+		DeriveSecretContext: []byte("test context"),
+		AppKeyLabel:         []byte("app"),
+		AppKeyGeneration:    7,
+		Cases:               make([]kdfTestVectorCase, len(supportedSuites)),
+	}
+
+	for i, suite := range supportedSuites {
+		tc := &tv.Cases[i]
+		tc.CipherSuite = suite
+
+		tc.HKDFExtractOut = suite.hkdfExtract(tv.HKDFExtractSalt, tv.HKDFExtractIKM)
+
+		tc.HKDFExpandOut = make([]kdfExpandCase, len(tv.ExpandLengths))
+		for j, length := range tv.ExpandLengths {
+			tc.HKDFExpandOut[j] = kdfExpandCase{
+				Length: length,
+				Out:    suite.hkdfExpand(tc.HKDFExtractOut, tv.ExpandInfo, int(length)),
+			}
+		}
+
+		tc.DeriveSecretOut = suite.DeriveSecret(tc.HKDFExtractOut, string(tv.DeriveSecretLabel), tv.DeriveSecretContext)
+
+		tc.AppKey, tc.AppNonce = suite.DeriveAppKeys(tc.DeriveSecretOut, string(tv.AppKeyLabel), tv.AppKeyGeneration)
+
+		params := suite.params()
+		if len(tc.AppKey) != params.aeadKeySize {
+			t.Fatalf("%v: derived app key length %d != AEAD key size %d", suite, len(tc.AppKey), params.aeadKeySize)
+		}
+		if len(tc.AppNonce) != params.aeadNonceSize {
+			t.Fatalf("%v: derived app nonce length %d != AEAD nonce size %d", suite, len(tc.AppNonce), params.aeadNonceSize)
+		}
+	}
+
+	vec, err := syntax.Marshal(tv)
+	assertNotError(t, err, "Error marshaling KDF test vectors")
+	return vec
+}
+
+func verifyKDFVectors(t *testing.T, data []byte) {
+	var tv KDFTestVectors
+	_, err := syntax.Unmarshal(data, &tv)
+	assertNotError(t, err, "Malformed KDF test vectors")
+
+	for _, tc := range tv.Cases {
+		suite := tc.CipherSuite
+
+		extractOut := suite.hkdfExtract(tv.HKDFExtractSalt, tv.HKDFExtractIKM)
+		assertByteEquals(t, extractOut, tc.HKDFExtractOut)
+
+		for _, ec := range tc.HKDFExpandOut {
+			expandOut := suite.hkdfExpand(extractOut, tv.ExpandInfo, int(ec.Length))
+			assertByteEquals(t, expandOut, ec.Out)
+		}
+
+		deriveSecretOut := suite.DeriveSecret(extractOut, string(tv.DeriveSecretLabel), tv.DeriveSecretContext)
+		assertByteEquals(t, deriveSecretOut, tc.DeriveSecretOut)
+
+		appKey, appNonce := suite.DeriveAppKeys(deriveSecretOut, string(tv.AppKeyLabel), tv.AppKeyGeneration)
+		assertByteEquals(t, appKey, tc.AppKey)
+		assertByteEquals(t, appNonce, tc.AppNonce)
+
+		params := suite.params()
+		assertEquals(t, len(appKey), params.aeadKeySize)
+		assertEquals(t, len(appNonce), params.aeadNonceSize)
+	}
+}
+
+func TestKDFVectors(t *testing.T) {
+	if *updateKDFVectors {
+		vec := generateKDFVectors(t)
+
+		if err := os.MkdirAll(filepath.Dir(kdfVectorsPath), 0755); err != nil {
+			t.Fatalf("Error creating testdata directory: %v", err)
+		}
+		if err := os.WriteFile(kdfVectorsPath, vec, 0644); err != nil {
+			t.Fatalf("Error writing KDF test vectors: %v", err)
+		}
+		return
+	}
+
+	data, err := os.ReadFile(kdfVectorsPath)
+	if err != nil {
+		t.Skipf("No KDF test vector fixture at %s; run `go test -run TestKDFVectors -update` to generate one", kdfVectorsPath)
+	}
+
+	verifyKDFVectors(t, data)
+}
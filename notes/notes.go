@@ -0,0 +1,288 @@
+// Package notes implements a diff-able, greppable signed-note encoding for
+// an MLS epoch's public state: tree hash, epoch number, group id, and
+// confirmation tag. The format follows the "signed note" convention used
+// elsewhere for append-only logs (e.g. Go's checksum database): a UTF-8
+// text block terminated by a blank line, followed by one or more signature
+// lines of the form
+//
+//	— <keyname> <base64(keyhash4 || sig)>
+//
+// so that a delivery service and one or more group members can co-sign the
+// same epoch transition without inventing a new binary envelope, and an
+// operator can audit the history with nothing more than a pager and diff.
+package notes
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	mls "github.com/bifurcation/mls"
+)
+
+// signaturePrefix is the em-dash marker that opens a signature line, as in
+// Go's note.Sign format.
+const signaturePrefix = "— "
+
+// EpochState is the public state of an MLS epoch that a note attests to.
+type EpochState struct {
+	GroupID         []byte
+	Epoch           uint64
+	TreeHash        []byte
+	ConfirmationTag []byte
+}
+
+// Verifier is a named public key that Open will accept a co-signature from.
+type Verifier struct {
+	Name   string
+	Scheme mls.SignatureScheme
+	Public mls.SignaturePublicKey
+}
+
+// Signature is one parsed and (for the verifiers Open was given) verified
+// signature line.
+type Signature struct {
+	KeyName string
+	KeyHash [4]byte
+}
+
+// Note is a signed note that has been successfully parsed and verified by
+// Open: the epoch state it attests to, the raw text block it was signed
+// over, and the signatures that verified.
+type Note struct {
+	State      EpochState
+	Text       string
+	Signatures []Signature
+}
+
+// keyHash is the first 4 bytes of SHA-256(keyname || 0x0A || algid ||
+// pubkey), used to let a signature line identify which key signed it
+// without embedding the full public key.
+func keyHash(name string, scheme mls.SignatureScheme, pub []byte) [4]byte {
+	h := sha256.New()
+	h.Write([]byte(name))
+	h.Write([]byte{0x0A})
+	h.Write([]byte{byte(scheme >> 8), byte(scheme)})
+	h.Write(pub)
+
+	sum := h.Sum(nil)
+	var out [4]byte
+	copy(out[:], sum[:4])
+	return out
+}
+
+// encodeText renders state as the note's human-readable text block,
+// including its terminating blank line.
+func encodeText(state EpochState) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "group-id: %s\n", base64.StdEncoding.EncodeToString(state.GroupID))
+	fmt.Fprintf(&b, "epoch: %d\n", state.Epoch)
+	fmt.Fprintf(&b, "tree-hash: %s\n", base64.StdEncoding.EncodeToString(state.TreeHash))
+	fmt.Fprintf(&b, "confirmation-tag: %s\n", base64.StdEncoding.EncodeToString(state.ConfirmationTag))
+	b.WriteString("\n")
+	return b.String()
+}
+
+func decodeText(text string) (EpochState, error) {
+	var state EpochState
+	seen := map[string]bool{}
+
+	for _, line := range strings.Split(text, "\n") {
+		if line == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ": ")
+		if !ok {
+			return EpochState{}, fmt.Errorf("notes: malformed state line %q", line)
+		}
+
+		switch key {
+		case "group-id":
+			data, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return EpochState{}, fmt.Errorf("notes: malformed group-id: %w", err)
+			}
+			state.GroupID = data
+		case "epoch":
+			n, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return EpochState{}, fmt.Errorf("notes: malformed epoch: %w", err)
+			}
+			state.Epoch = n
+		case "tree-hash":
+			data, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return EpochState{}, fmt.Errorf("notes: malformed tree-hash: %w", err)
+			}
+			state.TreeHash = data
+		case "confirmation-tag":
+			data, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return EpochState{}, fmt.Errorf("notes: malformed confirmation-tag: %w", err)
+			}
+			state.ConfirmationTag = data
+		default:
+			return EpochState{}, fmt.Errorf("notes: unknown state field %q", key)
+		}
+		seen[key] = true
+	}
+
+	for _, key := range []string{"group-id", "epoch", "tree-hash", "confirmation-tag"} {
+		if !seen[key] {
+			return EpochState{}, fmt.Errorf("notes: missing state field %q", key)
+		}
+	}
+
+	return state, nil
+}
+
+// Sign renders state as a note and signs it with signer under keyname,
+// returning the complete note (text block plus one signature line).
+func Sign(state EpochState, scheme mls.SignatureScheme, signer *mls.SignaturePrivateKey, keyname string) ([]byte, error) {
+	if strings.ContainsAny(keyname, " \n") {
+		return nil, fmt.Errorf("notes: key name %q must not contain spaces or newlines", keyname)
+	}
+
+	text := encodeText(state)
+
+	sig, err := scheme.Sign(signer, []byte(text))
+	if err != nil {
+		return nil, err
+	}
+
+	return appendSignature(nil, text, scheme, keyname, signer.PublicKey.Data, sig), nil
+}
+
+// CoSign adds another signature line to an existing note, alongside
+// whatever signatures it already carries, so a delivery service and one or
+// more members can co-sign the same epoch transition.
+func CoSign(note []byte, scheme mls.SignatureScheme, signer *mls.SignaturePrivateKey, keyname string) ([]byte, error) {
+	text, _, err := splitNote(note)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := scheme.Sign(signer, []byte(text))
+	if err != nil {
+		return nil, err
+	}
+
+	return appendSignature(note, text, scheme, keyname, signer.PublicKey.Data, sig), nil
+}
+
+func appendSignature(note []byte, text string, scheme mls.SignatureScheme, keyname string, pub, sig []byte) []byte {
+	kh := keyHash(keyname, scheme, pub)
+	blob := append(append([]byte{}, kh[:]...), sig...)
+	line := signaturePrefix + keyname + " " + base64.StdEncoding.EncodeToString(blob) + "\n"
+
+	if note == nil {
+		return []byte(text + line)
+	}
+	return append(note, []byte(line)...)
+}
+
+func splitNote(data []byte) (text string, sigLines []string, err error) {
+	if !utf8.Valid(data) {
+		return "", nil, fmt.Errorf("notes: note is not valid UTF-8")
+	}
+	if !bytes.HasSuffix(data, []byte("\n")) {
+		return "", nil, fmt.Errorf("notes: note must end with a trailing newline")
+	}
+
+	idx := bytes.Index(data, []byte("\n\n"))
+	if idx < 0 {
+		return "", nil, fmt.Errorf("notes: note has no blank line separating text from signatures")
+	}
+
+	text = string(data[:idx+2])
+	rest := string(data[idx+2:])
+	for _, line := range strings.Split(strings.TrimSuffix(rest, "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		sigLines = append(sigLines, line)
+	}
+
+	if len(sigLines) == 0 {
+		return "", nil, fmt.Errorf("notes: note has no signature lines")
+	}
+
+	return text, sigLines, nil
+}
+
+// Open parses data as a signed note and verifies it against verifiers. A
+// note with several signature lines - the point of CoSign - can be opened
+// by a caller that only knows some of the signers: lines that don't match
+// any supplied verifier (unknown key hash, or outright malformed) are
+// ignored rather than rejecting the whole note, since any third party can
+// append such a line to an otherwise-valid note. Open only rejects the note
+// outright if its text isn't valid UTF-8 with a trailing newline, if a line
+// matching one of verifiers fails to verify (a known signer's line has been
+// tampered with), or if no line matched any supplied verifier at all.
+func Open(data []byte, verifiers ...Verifier) (*Note, error) {
+	text, sigLines, err := splitNote(data)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := decodeText(text)
+	if err != nil {
+		return nil, err
+	}
+
+	byHash := map[[4]byte]Verifier{}
+	for _, v := range verifiers {
+		byHash[keyHash(v.Name, v.Scheme, v.Public.Data)] = v
+	}
+
+	var verified []Signature
+	for _, line := range sigLines {
+		rest := strings.TrimPrefix(line, signaturePrefix)
+		if rest == line {
+			continue
+		}
+
+		name, b64, ok := strings.Cut(rest, " ")
+		if !ok {
+			continue
+		}
+
+		blob, err := base64.StdEncoding.DecodeString(b64)
+		if err != nil || len(blob) < 4 {
+			continue
+		}
+
+		var hash [4]byte
+		copy(hash[:], blob[:4])
+		sig := blob[4:]
+
+		v, ok := byHash[hash]
+		if !ok || v.Name != name {
+			continue
+		}
+
+		if !v.Scheme.Verify(&v.Public, []byte(text), sig) {
+			return nil, fmt.Errorf("notes: signature from %q does not verify", name)
+		}
+
+		verified = append(verified, Signature{KeyName: name, KeyHash: hash})
+	}
+
+	if len(verified) == 0 {
+		return nil, fmt.Errorf("notes: no signature line matched a supplied verifier")
+	}
+
+	sort.Slice(verified, func(i, j int) bool { return verified[i].KeyName < verified[j].KeyName })
+
+	return &Note{
+		State:      state,
+		Text:       text,
+		Signatures: verified,
+	}, nil
+}
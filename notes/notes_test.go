@@ -0,0 +1,135 @@
+package notes
+
+import (
+	"testing"
+
+	mls "github.com/bifurcation/mls"
+)
+
+func TestSignOpenRoundTrip(t *testing.T) {
+	scheme := mls.Ed25519
+
+	dsPriv, err := scheme.Generate()
+	if err != nil {
+		t.Fatalf("Error generating delivery service key: %v", err)
+	}
+
+	state := EpochState{
+		GroupID:         []byte("group-1"),
+		Epoch:           4,
+		TreeHash:        []byte{1, 2, 3, 4},
+		ConfirmationTag: []byte{5, 6, 7, 8},
+	}
+
+	note, err := Sign(state, scheme, &dsPriv, "delivery-service")
+	if err != nil {
+		t.Fatalf("Error signing note: %v", err)
+	}
+
+	verifier := Verifier{Name: "delivery-service", Scheme: scheme, Public: dsPriv.PublicKey}
+
+	opened, err := Open(note, verifier)
+	if err != nil {
+		t.Fatalf("Error opening note: %v", err)
+	}
+
+	if opened.State.Epoch != state.Epoch {
+		t.Fatalf("Incorrect epoch: %v != %v", opened.State.Epoch, state.Epoch)
+	}
+	if len(opened.Signatures) != 1 || opened.Signatures[0].KeyName != "delivery-service" {
+		t.Fatalf("Incorrect signatures: %v", opened.Signatures)
+	}
+}
+
+func TestOpenRejectsUnknownVerifier(t *testing.T) {
+	scheme := mls.Ed25519
+
+	priv, err := scheme.Generate()
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+
+	state := EpochState{GroupID: []byte("g"), Epoch: 1, TreeHash: []byte{0}, ConfirmationTag: []byte{0}}
+	note, err := Sign(state, scheme, &priv, "member-1")
+	if err != nil {
+		t.Fatalf("Error signing note: %v", err)
+	}
+
+	other, err := scheme.Generate()
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+
+	_, err = Open(note, Verifier{Name: "member-1", Scheme: scheme, Public: other.PublicKey})
+	if err == nil {
+		t.Fatalf("Open should reject a note with no matching verifier")
+	}
+}
+
+func TestOpenIgnoresUnknownSignatureLine(t *testing.T) {
+	scheme := mls.Ed25519
+
+	priv, err := scheme.Generate()
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+
+	state := EpochState{GroupID: []byte("g"), Epoch: 1, TreeHash: []byte{0}, ConfirmationTag: []byte{0}}
+	note, err := Sign(state, scheme, &priv, "member-1")
+	if err != nil {
+		t.Fatalf("Error signing note: %v", err)
+	}
+
+	stranger, err := scheme.Generate()
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	note, err = CoSign(note, scheme, &stranger, "stranger")
+	if err != nil {
+		t.Fatalf("Error co-signing note: %v", err)
+	}
+
+	opened, err := Open(note, Verifier{Name: "member-1", Scheme: scheme, Public: priv.PublicKey})
+	if err != nil {
+		t.Fatalf("Open should ignore a signature line from a verifier it wasn't given: %v", err)
+	}
+	if len(opened.Signatures) != 1 || opened.Signatures[0].KeyName != "member-1" {
+		t.Fatalf("Incorrect signatures: %v", opened.Signatures)
+	}
+}
+
+func TestSignCoSign(t *testing.T) {
+	scheme := mls.Ed25519
+
+	dsPriv, err := scheme.Generate()
+	if err != nil {
+		t.Fatalf("Error generating delivery service key: %v", err)
+	}
+	memberPriv, err := scheme.Generate()
+	if err != nil {
+		t.Fatalf("Error generating member key: %v", err)
+	}
+
+	state := EpochState{GroupID: []byte("g"), Epoch: 2, TreeHash: []byte{9}, ConfirmationTag: []byte{9}}
+
+	note, err := Sign(state, scheme, &dsPriv, "delivery-service")
+	if err != nil {
+		t.Fatalf("Error signing note: %v", err)
+	}
+
+	note, err = CoSign(note, scheme, &memberPriv, "member-1")
+	if err != nil {
+		t.Fatalf("Error co-signing note: %v", err)
+	}
+
+	opened, err := Open(note,
+		Verifier{Name: "delivery-service", Scheme: scheme, Public: dsPriv.PublicKey},
+		Verifier{Name: "member-1", Scheme: scheme, Public: memberPriv.PublicKey},
+	)
+	if err != nil {
+		t.Fatalf("Error opening co-signed note: %v", err)
+	}
+	if len(opened.Signatures) != 2 {
+		t.Fatalf("Expected 2 signatures, got %d", len(opened.Signatures))
+	}
+}
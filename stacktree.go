@@ -0,0 +1,122 @@
+package mls
+
+import "fmt"
+
+// stackEntry is one (level, node) pair held by a StackTree while it waits
+// to be combined with a sibling at the same level.
+type stackEntry struct {
+	level uint
+	node  Node
+}
+
+// StackTree builds a tree one leaf at a time, collapsing interior nodes as
+// soon as both of their children are known. Root and Frontier are always
+// computed from only O(log N) *interior* nodes held on the stack (the stack
+// is exactly the tree's Frontier), never the full 2N-1-entry nodes map that
+// newTreeFromLeaves builds up front - this is what makes a StackTree usable
+// for a root hash or frontier over a very large member list (welcome flows,
+// migrations) without paying for a fully materialized tree.
+//
+// Finalize does not share that bound: it needs every leaf to hand back an
+// ordinary, fully-materialized *tree for group operation, which is
+// inherently an O(N) structure. A StackTree built with keepLeaves=false
+// never retains them and so cannot Finalize; pass keepLeaves=true only when
+// the tree is small enough that materializing it is acceptable.
+type StackTree struct {
+	defn       *nodeDefinition
+	blank      Node
+	keepLeaves bool
+	count      uint
+	stack      []stackEntry
+	leaves     []Node
+}
+
+// NewStackTree creates an empty StackTree using defn, padding with blank
+// when Finalize rounds the leaf count up to the next power of two. Leaves
+// are retained for Finalize only if keepLeaves is true; otherwise the
+// StackTree stays O(log N) for its whole life but Finalize always errors.
+func NewStackTree(defn *nodeDefinition, blank Node, keepLeaves bool) *StackTree {
+	return &StackTree{defn: defn, blank: blank, keepLeaves: keepLeaves}
+}
+
+// Append adds the next leaf to the tree, folding it into the stack and
+// collapsing any pairs of equal-level entries into their parent.
+func (s *StackTree) Append(leaf Node) error {
+	if s.keepLeaves {
+		s.leaves = append(s.leaves, leaf)
+	}
+	s.count++
+	s.stack = append(s.stack, stackEntry{level: 0, node: leaf})
+
+	for len(s.stack) >= 2 {
+		top := s.stack[len(s.stack)-1]
+		next := s.stack[len(s.stack)-2]
+		if top.level != next.level {
+			break
+		}
+
+		data, err := s.defn.combine(next.node, top.node)
+		if err != nil {
+			return err
+		}
+
+		parent := stackEntry{level: top.level + 1, node: s.defn.create(data)}
+		s.stack = append(s.stack[:len(s.stack)-2], parent)
+	}
+
+	return nil
+}
+
+// Root returns the root of the tree built so far. It is only well-defined
+// once the stack has collapsed to a single entry, i.e. once the number of
+// leaves appended is a power of two.
+func (s *StackTree) Root() (Node, error) {
+	if len(s.stack) != 1 {
+		return nil, fmt.Errorf("mls: root is undefined for a stack tree of size %d with an incomplete frontier", s.count)
+	}
+	return s.stack[0].node, nil
+}
+
+// Frontier returns the StackTree's current stack as a Frontier, from the
+// largest (leftmost) complete subtree to the smallest (rightmost). This is
+// the same decomposition (*tree).Frontier computes from a fully
+// materialized tree - the stack *is* the frontier.
+func (s *StackTree) Frontier() *Frontier {
+	f := &Frontier{Entries: make([]FrontierEntry, len(s.stack))}
+	for i, e := range s.stack {
+		f.Entries[i] = FrontierEntry{Value: e.node, Size: 1 << e.level}
+	}
+	return f
+}
+
+// Finalize pads the tree with blank leaves up to the next power of two and
+// returns a fully-materialized *tree equal to calling newTreeFromLeaves with
+// the same (now-padded) leaves. It requires a StackTree built with
+// keepLeaves=true, since reconstructing the tree's interior nodes needs
+// every leaf, not just the O(log N) ones the stack retains.
+func (s *StackTree) Finalize() (*tree, error) {
+	if !s.keepLeaves {
+		return nil, fmt.Errorf("mls: stack tree was not configured to retain leaves, cannot Finalize")
+	}
+
+	target := nextPowerOfTwo(s.count)
+	for s.count < target {
+		if err := s.Append(s.blank); err != nil {
+			return nil, err
+		}
+	}
+
+	return newTreeFromLeaves(s.defn, s.leaves)
+}
+
+func nextPowerOfTwo(n uint) uint {
+	if n == 0 {
+		return 1
+	}
+
+	p := uint(1)
+	for p < n {
+		p *= 2
+	}
+	return p
+}
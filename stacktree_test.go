@@ -0,0 +1,79 @@
+package mls
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStackTreeMatchesTreeFromLeaves(t *testing.T) {
+	leaves := []Node{"a", "b", "c", "d", "e"}
+
+	st := NewStackTree(stringNodeDefn, "", true)
+	for _, leaf := range leaves {
+		if err := st.Append(leaf); err != nil {
+			t.Fatalf("Error appending leaf: %v", err)
+		}
+	}
+
+	finalized, err := st.Finalize()
+	if err != nil {
+		t.Fatalf("Error finalizing stack tree: %v", err)
+	}
+
+	padded := append(append([]Node{}, leaves...), "", "", "")
+	direct, err := newTreeFromLeaves(stringNodeDefn, padded)
+	if err != nil {
+		t.Fatalf("Error constructing tree from leaves: %v", err)
+	}
+
+	if !finalized.Equal(direct) {
+		t.Fatalf("Finalized stack tree does not match tree built from leaves: %v != %v", finalized.nodes, direct.nodes)
+	}
+}
+
+func TestStackTreeRootMatchesFrontier(t *testing.T) {
+	st := NewStackTree(stringNodeDefn, "", false)
+	for _, leaf := range []Node{"a", "b", "c", "d"} {
+		if err := st.Append(leaf); err != nil {
+			t.Fatalf("Error appending leaf: %v", err)
+		}
+	}
+
+	root, err := st.Root()
+	if err != nil {
+		t.Fatalf("Error computing root: %v", err)
+	}
+	if root != "abcd" {
+		t.Fatalf("Incorrect stack tree root: %v != %v", root, "abcd")
+	}
+
+	frontier := st.Frontier()
+	expected := &Frontier{Entries: []FrontierEntry{{Value: "abcd", Size: 4}}}
+	if !reflect.DeepEqual(frontier, expected) {
+		t.Fatalf("Incorrect frontier for a complete stack tree: %v != %v", frontier, expected)
+	}
+}
+
+func TestStackTreeIncompleteRootErrors(t *testing.T) {
+	st := NewStackTree(stringNodeDefn, "", false)
+	for _, leaf := range []Node{"a", "b", "c"} {
+		if err := st.Append(leaf); err != nil {
+			t.Fatalf("Error appending leaf: %v", err)
+		}
+	}
+
+	if _, err := st.Root(); err == nil {
+		t.Fatalf("Root should fail on an incomplete frontier")
+	}
+}
+
+func TestStackTreeFinalizeRequiresKeptLeaves(t *testing.T) {
+	st := NewStackTree(stringNodeDefn, "", false)
+	if err := st.Append("a"); err != nil {
+		t.Fatalf("Error appending leaf: %v", err)
+	}
+
+	if _, err := st.Finalize(); err == nil {
+		t.Fatalf("Finalize should fail on a stack tree that was not configured to retain leaves")
+	}
+}
@@ -0,0 +1,82 @@
+package mls
+
+import (
+	"github.com/bifurcation/mint/syntax"
+)
+
+// WriteStream accumulates a sequence of values, each encoded with the TLS
+// presentation language rules from github.com/bifurcation/mint/syntax, into
+// a single byte stream. It lets callers build up a wire-format message field
+// by field instead of having to define a wrapper struct for every message.
+type WriteStream struct {
+	data []byte
+}
+
+// NewWriteStream creates an empty WriteStream.
+func NewWriteStream() *WriteStream {
+	return &WriteStream{}
+}
+
+// Write encodes val and appends it to the stream.
+func (w *WriteStream) Write(val interface{}) error {
+	enc, err := syntax.Marshal(val)
+	if err != nil {
+		return err
+	}
+
+	w.data = append(w.data, enc...)
+	return nil
+}
+
+// WriteAll encodes each of vals in order and appends them to the stream.
+func (w *WriteStream) WriteAll(vals ...interface{}) error {
+	for _, val := range vals {
+		if err := w.Write(val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Data returns the bytes written to the stream so far.
+func (w *WriteStream) Data() []byte {
+	return w.data
+}
+
+// ReadStream consumes a sequence of TLS-syntax-encoded values from a single
+// byte stream, tracking how much of the underlying data has been consumed.
+type ReadStream struct {
+	data []byte
+}
+
+// NewReadStream creates a ReadStream over data.
+func NewReadStream(data []byte) *ReadStream {
+	return &ReadStream{data: data}
+}
+
+// Read decodes the next value into val and returns the number of bytes
+// consumed.
+func (r *ReadStream) Read(val interface{}) (int, error) {
+	read, err := syntax.Unmarshal(r.data, val)
+	if err != nil {
+		return 0, err
+	}
+
+	r.data = r.data[read:]
+	return read, nil
+}
+
+// ReadAll decodes each of vals in order and returns the total number of
+// bytes consumed.
+func (r *ReadStream) ReadAll(vals ...interface{}) (int, error) {
+	total := 0
+	for _, val := range vals {
+		read, err := r.Read(val)
+		if err != nil {
+			return total, err
+		}
+
+		total += read
+	}
+	return total, nil
+}
@@ -0,0 +1,148 @@
+package mls
+
+// This file implements the "array-based" complete-binary-tree indexing
+// scheme used throughout the package: a tree over n leaves is laid out as a
+// flat array of 2n-1 nodes, with leaf i at array index 2i and internal nodes
+// interleaved at odd indices. All three of nodeIndex, leafCount, and
+// nodeCount are plain aliases for uint; the distinct names exist purely to
+// document which quantity a value represents at a given call site.
+type (
+	nodeIndex = uint
+	leafCount = uint
+	nodeCount = uint
+)
+
+// log2 returns the position of the most significant set bit of x (i.e.
+// floor(log2(x))), treating log2(0) as 0.
+func log2(x nodeCount) uint {
+	if x == 0 {
+		return 0
+	}
+
+	k := uint(0)
+	for x > 1 {
+		k++
+		x >>= 1
+	}
+	return k
+}
+
+// level returns the number of trailing one-bits in x's binary
+// representation, which is also the height of node x above the leaves in
+// the array-based tree layout (leaves are even and have level 0).
+func level(x nodeIndex) uint {
+	if x&0x01 == 0 {
+		return 0
+	}
+
+	k := uint(0)
+	for (x>>k)&0x01 == 1 {
+		k++
+	}
+	return k
+}
+
+// nodeWidth is the number of array slots needed to hold a tree with n
+// leaves.
+func nodeWidth(n leafCount) nodeCount {
+	if n == 0 {
+		return 0
+	}
+	return nodeCount(2*(n-1) + 1)
+}
+
+// root is the index of the root node of a tree with n leaves.
+func root(n leafCount) nodeIndex {
+	w := nodeWidth(n)
+	return nodeIndex((uint(1) << log2(w)) - 1)
+}
+
+// left is the index of x's left child. It is undefined (returns x) for a
+// leaf.
+func left(x nodeIndex) nodeIndex {
+	lvl := level(x)
+	if lvl == 0 {
+		return x
+	}
+	return x ^ (1 << (lvl - 1))
+}
+
+// right is the index of x's right child within a tree of n leaves. It is
+// undefined (returns x) for a leaf.
+func right(x nodeIndex, n leafCount) nodeIndex {
+	lvl := level(x)
+	if lvl == 0 {
+		return x
+	}
+
+	r := x ^ (3 << (lvl - 1))
+	for r >= nodeWidth(n) {
+		r = left(r)
+	}
+	return r
+}
+
+// parentStep computes the binary-tree parent of x, ignoring that the result
+// may fall outside a tree whose right edge is not yet complete.
+func parentStep(x nodeIndex) nodeIndex {
+	k := level(x)
+	b := (x >> (k + 1)) & 0x01
+	return (x | (1 << k)) ^ (b << (k + 1))
+}
+
+// parent is the index of x's parent within a tree of n leaves. The root is
+// its own parent. Where the tree's right edge is incomplete, parentStep can
+// land outside the tree's node width; climb further in that case.
+func parent(x nodeIndex, n leafCount) nodeIndex {
+	if x == root(n) {
+		return x
+	}
+
+	p := parentStep(x)
+	for p >= nodeWidth(n) {
+		p = parentStep(p)
+	}
+	return p
+}
+
+// sibling is the index of x's sibling (the other child of x's parent)
+// within a tree of n leaves. The root has no sibling and is its own.
+func sibling(x nodeIndex, n leafCount) nodeIndex {
+	if x == root(n) {
+		return x
+	}
+
+	p := parent(x, n)
+	if x < p {
+		return right(p, n)
+	}
+	return left(p)
+}
+
+// dirpath is the list of nodes from x up to and including the root of a
+// tree of n leaves.
+func dirpath(x nodeIndex, n leafCount) []nodeIndex {
+	r := root(n)
+	path := []nodeIndex{x}
+	for x != r {
+		x = parent(x, n)
+		path = append(path, x)
+	}
+	return path
+}
+
+// copath is the list of siblings of every node on dirpath(x, n), excluding
+// the root (which has no sibling). Together with a leaf hash, the copath is
+// enough to recompute the root.
+func copath(x nodeIndex, n leafCount) []nodeIndex {
+	d := dirpath(x, n)
+	if len(d) <= 1 {
+		return []nodeIndex{}
+	}
+
+	path := make([]nodeIndex, 0, len(d)-1)
+	for _, y := range d[:len(d)-1] {
+		path = append(path, sibling(y, n))
+	}
+	return path
+}
@@ -0,0 +1,667 @@
+package mls
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// nodeDefinition tells a tree how to create, combine, and validate the
+// opaque Node values stored at its positions. Different uses of tree (a
+// ratchet tree of HPKE public keys, a Merkle tree of content hashes, ...)
+// plug in their own definition.
+type nodeDefinition struct {
+	// valid reports whether x is a well-formed node of the expected
+	// concrete type.
+	valid func(x Node) bool
+
+	// equal reports whether x and y represent the same value.
+	equal func(x, y Node) bool
+
+	// create builds a Node from its serialized form, as produced by combine.
+	create func(d []byte) Node
+
+	// combine computes the serialized form of the parent of x (left child)
+	// and y (right child).
+	combine func(x, y Node) ([]byte, error)
+}
+
+// tree is a left-complete binary tree addressed with the array-based
+// indexing scheme from tree-math.go. Leaves are added strictly left to
+// right; internal nodes are recomputed from their children whenever a leaf
+// changes.
+type tree struct {
+	defn  *nodeDefinition
+	size  uint
+	nodes map[uint]Node
+}
+
+// newTree creates an empty tree using defn.
+func newTree(defn *nodeDefinition) *tree {
+	return &tree{
+		defn:  defn,
+		nodes: map[uint]Node{},
+	}
+}
+
+// newTreeFromLeaves builds a tree by adding leaves in order.
+func newTreeFromLeaves(defn *nodeDefinition, leaves []Node) (*tree, error) {
+	t := newTree(defn)
+	for _, leaf := range leaves {
+		if err := t.Add(leaf); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// valueAt returns the cached value at node index x, or an error if it has
+// not been computed yet.
+func (t *tree) valueAt(x nodeIndex) (Node, error) {
+	v, ok := t.nodes[uint(x)]
+	if !ok {
+		return nil, fmt.Errorf("mls: no value cached for node %d", x)
+	}
+	return v, nil
+}
+
+// combineAt recomputes the value of internal node x from its two children
+// and caches the result.
+func (t *tree) combineAt(x nodeIndex) error {
+	l, err := t.valueAt(left(x))
+	if err != nil {
+		return err
+	}
+
+	r, err := t.valueAt(right(x, t.size))
+	if err != nil {
+		return err
+	}
+
+	data, err := t.defn.combine(l, r)
+	if err != nil {
+		return err
+	}
+
+	t.nodes[uint(x)] = t.defn.create(data)
+	return nil
+}
+
+// Add appends a new leaf to the right edge of the tree, recombining every
+// ancestor on its direct path.
+func (t *tree) Add(leaf Node) error {
+	x := nodeIndex(2 * t.size)
+	t.nodes[uint(x)] = leaf
+	t.size++
+
+	r := root(t.size)
+	for x != r {
+		x = parent(x, t.size)
+		if err := t.combineAt(x); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Update replaces the leaf at index, recombining every ancestor on its
+// direct path.
+func (t *tree) Update(index uint, leaf Node) error {
+	if index >= t.size {
+		return fmt.Errorf("mls: leaf index %d out of range", index)
+	}
+
+	x := nodeIndex(2 * index)
+	t.nodes[uint(x)] = leaf
+
+	r := root(t.size)
+	for x != r {
+		x = parent(x, t.size)
+		if err := t.combineAt(x); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateWithPath applies an already-computed direct path to the leaf at
+// index: path holds the new values for the leaf and each of its ancestors,
+// in leaf-to-root order, stopping short of the tree's root. Any remaining
+// ancestors up to and including the root are recombined from their
+// children.
+func (t *tree) UpdateWithPath(index uint, path []Node) error {
+	if index >= t.size {
+		return fmt.Errorf("mls: leaf index %d out of range", index)
+	}
+
+	r := root(t.size)
+	x := nodeIndex(2 * index)
+	for _, v := range path {
+		t.nodes[uint(x)] = v
+		if x == r {
+			return nil
+		}
+		x = parent(x, t.size)
+	}
+
+	for {
+		if err := t.combineAt(x); err != nil {
+			return err
+		}
+		if x == r {
+			break
+		}
+		x = parent(x, t.size)
+	}
+	return nil
+}
+
+// UpdatePath computes the direct path that would result from replacing the
+// leaf at index with leaf, applying it to the tree and returning the new
+// node values from the leaf's closest-to-root ancestor down to the leaf
+// itself (mirroring the order UpdateWithPath expects on the receiving side,
+// reversed).
+func (t *tree) UpdatePath(index uint, leaf Node) ([]Node, error) {
+	if index >= t.size {
+		return nil, fmt.Errorf("mls: leaf index %d out of range", index)
+	}
+
+	r := root(t.size)
+	x := nodeIndex(2 * index)
+	t.nodes[uint(x)] = leaf
+	path := []Node{leaf}
+
+	for x != r {
+		x = parent(x, t.size)
+		if err := t.combineAt(x); err != nil {
+			return nil, err
+		}
+		if x != r {
+			path = append(path, t.nodes[uint(x)])
+		}
+	}
+
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path, nil
+}
+
+// Leaves returns the tree's leaves in order.
+func (t *tree) Leaves() []Node {
+	leaves := make([]Node, t.size)
+	for i := uint(0); i < t.size; i++ {
+		leaves[i] = t.nodes[uint(2*i)]
+	}
+	return leaves
+}
+
+// HasAllLeaves reports whether every leaf slot has a value.
+func (t *tree) HasAllLeaves() bool {
+	for i := uint(0); i < t.size; i++ {
+		if _, ok := t.nodes[uint(2*i)]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Equal reports whether t and o have the same size and the same value at
+// every cached node.
+func (t *tree) Equal(o *tree) bool {
+	if t.size != o.size || len(t.nodes) != len(o.nodes) {
+		return false
+	}
+
+	for k, v := range t.nodes {
+		ov, ok := o.nodes[k]
+		if !ok || !t.defn.equal(v, ov) {
+			return false
+		}
+	}
+	return true
+}
+
+// FrontierEntry is the root of one of the maximal complete subtrees that
+// make up a Frontier.
+type FrontierEntry struct {
+	Value Node
+	Size  uint
+}
+
+// Frontier is the left-to-right decomposition of a tree into the smallest
+// number of maximal complete (power-of-two-sized) subtrees. It is the same
+// decomposition a StackTree holds on its stack while it is being built.
+type Frontier struct {
+	Entries []FrontierEntry
+}
+
+// Frontier computes t's frontier.
+func (t *tree) Frontier() *Frontier {
+	f := &Frontier{}
+
+	offset := uint(0)
+	remaining := t.size
+	for remaining > 0 {
+		size := uint(1)
+		for size*2 <= remaining {
+			size *= 2
+		}
+
+		idx := root(size) + 2*offset
+		f.Entries = append(f.Entries, FrontierEntry{
+			Value: t.nodes[idx],
+			Size:  size,
+		})
+
+		offset += size
+		remaining -= size
+	}
+
+	return f
+}
+
+// Copath is the set of node values making up copath(2*index, t.size),
+// together with the index and tree size they were computed against.
+type Copath struct {
+	Index uint
+	Size  uint
+	Nodes []Node
+}
+
+// Copath returns the copath of the leaf at index.
+func (t *tree) Copath(index uint) *Copath {
+	path := copath(nodeIndex(2*index), t.size)
+
+	nodes := make([]Node, len(path))
+	for i, x := range path {
+		nodes[i] = t.nodes[uint(x)]
+	}
+
+	return &Copath{
+		Index: index,
+		Size:  t.size,
+		Nodes: nodes,
+	}
+}
+
+///
+/// Signed tree heads and audit proofs
+///
+///
+/// A TreeHead lets a group member who trusts a single signing key confirm
+/// that a delivery service's claimed ratchet-tree state is the same one
+/// every other member saw, following the log-audit pattern from RFC 6962
+/// ("Certificate Transparency"): an InclusionProof ties one leaf to a signed
+/// root, and a ConsistencyProof ties two signed roots (at different sizes)
+/// to each other, without either party downloading the full tree.
+///
+
+const (
+	leafHashPrefix     = byte(0x01)
+	interiorHashPrefix = byte(0x00)
+)
+
+// TreeHead is a signed statement about the state of a tree at a point in
+// time: its size, the hash of its root, and when that hash was computed.
+type TreeHead struct {
+	TreeSize  uint64
+	RootHash  []byte `tls:"head=1"`
+	Timestamp uint64
+}
+
+// SignedTreeHead is a TreeHead together with a signature over its encoding.
+type SignedTreeHead struct {
+	TreeHead
+	Signature []byte `tls:"head=2"`
+}
+
+// hashDefinition is a nodeDefinition for a tree whose nodes are the raw
+// hash output of suite, domain-separating interior nodes from leaves as in
+// RFC 6962 so that a leaf hash can never be confused with an interior hash.
+func hashDefinition(suite CipherSuite) *nodeDefinition {
+	return &nodeDefinition{
+		valid: func(x Node) bool {
+			_, ok := x.([]byte)
+			return ok
+		},
+		equal: func(x, y Node) bool {
+			xb, okx := x.([]byte)
+			yb, oky := y.([]byte)
+			return okx && oky && bytesEqual(xb, yb)
+		},
+		create: func(d []byte) Node {
+			return d
+		},
+		combine: func(x, y Node) ([]byte, error) {
+			xb, okx := x.([]byte)
+			yb, oky := y.([]byte)
+			if !okx || !oky {
+				return nil, InvalidNodeError
+			}
+
+			in := make([]byte, 0, 1+len(xb)+len(yb))
+			in = append(in, interiorHashPrefix)
+			in = append(in, xb...)
+			in = append(in, yb...)
+			return suite.digest(in), nil
+		},
+	}
+}
+
+func bytesEqual(a, b []byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// leafHash hashes a leaf's content with the RFC 6962-style leaf prefix.
+func leafHash(suite CipherSuite, data []byte) []byte {
+	in := make([]byte, 0, 1+len(data))
+	in = append(in, leafHashPrefix)
+	in = append(in, data...)
+	return suite.digest(in)
+}
+
+// Head computes the (unsigned) TreeHead for t at the given time.
+func (t *tree) Head(suite CipherSuite, at time.Time) (TreeHead, error) {
+	if t.size == 0 {
+		return TreeHead{}, fmt.Errorf("mls: cannot take the head of an empty tree")
+	}
+
+	rootValue, err := t.valueAt(root(t.size))
+	if err != nil {
+		return TreeHead{}, err
+	}
+
+	rootBytes, ok := rootValue.([]byte)
+	if !ok {
+		return TreeHead{}, InvalidNodeError
+	}
+
+	return TreeHead{
+		TreeSize:  uint64(t.size),
+		RootHash:  rootBytes,
+		Timestamp: uint64(at.Unix()),
+	}, nil
+}
+
+// Sign produces a SignedTreeHead over head using signer.
+func (head TreeHead) Sign(scheme SignatureScheme, signer *SignaturePrivateKey) (SignedTreeHead, error) {
+	sig, err := scheme.Sign(signer, head.encode())
+	if err != nil {
+		return SignedTreeHead{}, err
+	}
+
+	return SignedTreeHead{TreeHead: head, Signature: sig}, nil
+}
+
+// Verify checks sth's signature against verifier.
+func (sth SignedTreeHead) Verify(scheme SignatureScheme, verifier *SignaturePublicKey) bool {
+	return scheme.Verify(verifier, sth.TreeHead.encode(), sth.Signature)
+}
+
+func (head TreeHead) encode() []byte {
+	buf := make([]byte, 16+len(head.RootHash))
+	binary.BigEndian.PutUint64(buf[0:8], head.TreeSize)
+	binary.BigEndian.PutUint64(buf[8:16], head.Timestamp)
+	copy(buf[16:], head.RootHash)
+	return buf
+}
+
+// InclusionProof is the copath of a leaf, truncated to the size a
+// SignedTreeHead was computed at, so that hashing it pairwise against the
+// leaf reproduces the signed root.
+type InclusionProof struct {
+	LeafIndex uint64
+	TreeSize  uint64
+	PathHash  [][]byte `tls:"head=2"`
+}
+
+// InclusionProof builds the audit path for the leaf at leafIndex.
+func (t *tree) InclusionProof(leafIndex uint) (InclusionProof, error) {
+	if leafIndex >= t.size {
+		return InclusionProof{}, fmt.Errorf("mls: leaf index %d out of range", leafIndex)
+	}
+
+	path := copath(nodeIndex(2*leafIndex), t.size)
+	hashes := make([][]byte, 0, len(path))
+	for _, x := range path {
+		v, err := t.valueAt(x)
+		if err != nil {
+			return InclusionProof{}, err
+		}
+
+		hb, ok := v.([]byte)
+		if !ok {
+			return InclusionProof{}, InvalidNodeError
+		}
+		hashes = append(hashes, hb)
+	}
+
+	return InclusionProof{
+		LeafIndex: uint64(leafIndex),
+		TreeSize:  uint64(t.size),
+		PathHash:  hashes,
+	}, nil
+}
+
+// VerifyInclusion checks that leafHash (the already-domain-separated leaf
+// hash produced by leafHash), combined pairwise up proof with the
+// domain-separation prefixes used by hashDefinition, reproduces head's
+// root for the leaf at index.
+func VerifyInclusion(suite CipherSuite, head TreeHead, leafHash []byte, index uint64, proof InclusionProof) bool {
+	if proof.TreeSize != head.TreeSize || proof.LeafIndex != index {
+		return false
+	}
+
+	n := leafCount(proof.TreeSize)
+	x := nodeIndex(2 * proof.LeafIndex)
+	hash := leafHash
+
+	for _, sib := range proof.PathHash {
+		p := parent(x, n)
+
+		var in []byte
+		if x == left(p) {
+			in = concatHash(interiorHashPrefix, hash, sib)
+		} else {
+			in = concatHash(interiorHashPrefix, sib, hash)
+		}
+		hash = suite.digest(in)
+		x = p
+	}
+
+	return bytesEqual(hash, head.RootHash)
+}
+
+func concatHash(prefix byte, a, b []byte) []byte {
+	out := make([]byte, 0, 1+len(a)+len(b))
+	out = append(out, prefix)
+	out = append(out, a...)
+	out = append(out, b...)
+	return out
+}
+
+// ConsistencyProof is the minimal set of subtree roots that let a verifier
+// reconstruct both an old root (from a prefix of the nodes that make up the
+// new tree) and the new root, certifying that the new tree is an append-only
+// extension of the old one.
+type ConsistencyProof struct {
+	OldSize uint64
+	NewSize uint64
+	Hashes  [][]byte `tls:"head=2"`
+}
+
+// ConsistencyProof builds the proof that the first oldSize leaves of t are
+// unchanged from when the tree had oldSize leaves. newSize must equal t's
+// current size; it is taken explicitly, rather than read off t, so the
+// proof a caller gets back is always for the size they asked for.
+func (t *tree) ConsistencyProof(oldSize, newSize uint) (ConsistencyProof, error) {
+	if newSize != t.size {
+		return ConsistencyProof{}, fmt.Errorf("mls: new size %d does not match tree of size %d", newSize, t.size)
+	}
+	if oldSize == 0 || oldSize > newSize {
+		return ConsistencyProof{}, fmt.Errorf("mls: invalid old size %d for tree of size %d", oldSize, newSize)
+	}
+
+	hashes, err := t.consistencyHashes(0, oldSize, newSize, true)
+	if err != nil {
+		return ConsistencyProof{}, err
+	}
+
+	return ConsistencyProof{
+		OldSize: uint64(oldSize),
+		NewSize: uint64(newSize),
+		Hashes:  hashes,
+	}, nil
+}
+
+// consistencyHashes implements the standard RFC 6962 SUBPROOF decomposition:
+// split at the largest power of two strictly less than newSize and recurse
+// into whichever side still straddles the old/new boundary, always also
+// emitting the sibling subtree's root so the verifier can rebuild both old
+// and new roots. sameRoot is true only while every split so far has kept
+// the old boundary on the left, i.e. while the subtree this call examines,
+// should its size already equal oldSize, is exactly the previously-signed
+// old root the verifier already holds - in which case no hash is needed at
+// all, since the verifier supplies that root itself rather than rederiving
+// it from the proof.
+func (t *tree) consistencyHashes(offset, oldSize, newSize uint, sameRoot bool) ([][]byte, error) {
+	if oldSize == newSize {
+		if sameRoot {
+			return nil, nil
+		}
+		h, err := t.subtreeHash(offset, newSize)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{h}, nil
+	}
+
+	k := largestPowerOfTwoBelow(newSize)
+
+	if oldSize <= k {
+		leftHashes, err := t.consistencyHashes(offset, oldSize, k, sameRoot)
+		if err != nil {
+			return nil, err
+		}
+
+		rightHash, err := t.subtreeHash(offset+k, newSize-k)
+		if err != nil {
+			return nil, err
+		}
+
+		return append(leftHashes, rightHash), nil
+	}
+
+	leftHash, err := t.subtreeHash(offset, k)
+	if err != nil {
+		return nil, err
+	}
+
+	rightHashes, err := t.consistencyHashes(offset+k, oldSize-k, newSize-k, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([][]byte{leftHash}, rightHashes...), nil
+}
+
+// subtreeHash returns the cached root hash of the complete subtree spanning
+// the size leaves starting at leaf offset.
+func (t *tree) subtreeHash(offset, size uint) ([]byte, error) {
+	idx := root(size) + 2*offset
+	v, err := t.valueAt(idx)
+	if err != nil {
+		return nil, err
+	}
+
+	hb, ok := v.([]byte)
+	if !ok {
+		return nil, InvalidNodeError
+	}
+	return hb, nil
+}
+
+// largestPowerOfTwoBelow returns the largest power of two strictly less
+// than n.
+func largestPowerOfTwoBelow(n uint) uint {
+	k := uint(1)
+	for k*2 < n {
+		k *= 2
+	}
+	return k
+}
+
+// VerifyConsistency checks that proof connects oldHead and newHead: that
+// replaying it reconstructs both oldHead's root and newHead's root from the
+// same pass over proof.Hashes.
+func VerifyConsistency(suite CipherSuite, oldHead, newHead TreeHead, proof ConsistencyProof) bool {
+	if proof.OldSize != oldHead.TreeSize || proof.NewSize != newHead.TreeSize {
+		return false
+	}
+	if proof.OldSize == proof.NewSize {
+		return len(proof.Hashes) == 0 && bytesEqual(oldHead.RootHash, newHead.RootHash)
+	}
+
+	oldRoot, newRoot, rest, ok := replayConsistency(suite, proof.Hashes, proof.OldSize, proof.NewSize, true, oldHead.RootHash)
+	if !ok || len(rest) != 0 {
+		return false
+	}
+	return bytesEqual(oldRoot, oldHead.RootHash) && bytesEqual(newRoot, newHead.RootHash)
+}
+
+// replayConsistency mirrors consistencyHashes, consuming hashes from the
+// front of remaining and reconstructing the old and new roots together in
+// one pass. sameRoot and knownOldRoot carry the same "still on the left
+// boundary" optimization consistencyHashes used when building the proof:
+// once a subtree's size reaches oldSize while sameRoot is still true, its
+// root is knownOldRoot itself rather than anything derived from the proof.
+func replayConsistency(suite CipherSuite, remaining [][]byte, oldSize, newSize uint64, sameRoot bool, knownOldRoot []byte) (oldRoot, newRoot []byte, rest [][]byte, ok bool) {
+	if oldSize == newSize {
+		if sameRoot {
+			return knownOldRoot, knownOldRoot, remaining, true
+		}
+		if len(remaining) == 0 {
+			return nil, nil, remaining, false
+		}
+		h := remaining[0]
+		return h, h, remaining[1:], true
+	}
+
+	k := uint64(largestPowerOfTwoBelow(uint(newSize)))
+
+	if oldSize <= k {
+		leftOldRoot, leftNewRoot, rest, ok := replayConsistency(suite, remaining, oldSize, k, sameRoot, knownOldRoot)
+		if !ok || len(rest) == 0 {
+			return nil, nil, rest, false
+		}
+		rightRoot := rest[0]
+		rest = rest[1:]
+
+		newRoot := suite.digest(concatHash(interiorHashPrefix, leftNewRoot, rightRoot))
+		return leftOldRoot, newRoot, rest, true
+	}
+
+	if len(remaining) == 0 {
+		return nil, nil, remaining, false
+	}
+	leftHash := remaining[0]
+	rest = remaining[1:]
+
+	rightOldRoot, rightNewRoot, rest, ok := replayConsistency(suite, rest, oldSize-k, newSize-k, false, nil)
+	if !ok {
+		return nil, nil, rest, false
+	}
+
+	oldRoot = suite.digest(concatHash(interiorHashPrefix, leftHash, rightOldRoot))
+	newRoot = suite.digest(concatHash(interiorHashPrefix, leftHash, rightNewRoot))
+	return oldRoot, newRoot, rest, true
+}